@@ -0,0 +1,156 @@
+package cauth
+
+import (
+	"math"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// breakerState is one of the three states of a circuitBreaker.
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerHalfOpen
+	breakerOpen
+)
+
+// defaultBreakerMinSamples is the minimum number of requests observed
+// in a window before the failure ratio is allowed to trip the breaker;
+// this avoids opening on a handful of unlucky requests right after
+// startup or a window rollover.
+const defaultBreakerMinSamples = 10
+
+// circuitBreaker isolates a rule's endpoint calls from the rest of the
+// server: once failures dominate a rolling window, it stops issuing
+// calls for a cooldown period and falls back to rule.BreakerFallback
+// instead of blocking every request on an unresponsive upstream.
+type circuitBreaker struct {
+	mu         sync.Mutex
+	rule       string
+	ratio      float64
+	window     time.Duration
+	minSamples int
+
+	state       breakerState
+	windowStart time.Time
+	total       int
+	failures    int
+	openedAt    time.Time
+}
+
+func newCircuitBreaker(rule string, ratio float64, window time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		rule:        rule,
+		ratio:       ratio,
+		window:      window,
+		minSamples:  defaultBreakerMinSamples,
+		windowStart: time.Now(),
+	}
+}
+
+// setState transitions the breaker to state and reflects it in the
+// cauth_breaker_state gauge.
+func (cb *circuitBreaker) setState(state breakerState) {
+	cb.state = state
+	breakerStateGauge.WithLabelValues(cb.rule).Set(float64(state))
+}
+
+// allow reports whether a call should be attempted. When the breaker is
+// open and the cooldown has elapsed, exactly one caller moves it to
+// half-open and is let through as the trial call; every other caller
+// is rejected until record() resolves that trial one way or the other.
+func (cb *circuitBreaker) allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case breakerOpen:
+		if time.Since(cb.openedAt) >= cb.window {
+			cb.setState(breakerHalfOpen)
+			return true
+		}
+		return false
+	case breakerHalfOpen:
+		// A trial call is already in flight; its result (via record)
+		// is what decides whether the breaker closes or reopens.
+		return false
+	default:
+		return true
+	}
+}
+
+// record updates the breaker with the outcome of a call. It rolls the
+// counting window over on expiry and trips the breaker open once the
+// failure ratio crosses the configured threshold.
+func (cb *circuitBreaker) record(success bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == breakerHalfOpen {
+		if success {
+			cb.setState(breakerClosed)
+			cb.total, cb.failures = 0, 0
+			cb.windowStart = time.Now()
+		} else {
+			cb.setState(breakerOpen)
+			cb.openedAt = time.Now()
+		}
+		return
+	}
+
+	if time.Since(cb.windowStart) > cb.window {
+		cb.total, cb.failures = 0, 0
+		cb.windowStart = time.Now()
+	}
+
+	cb.total++
+	if !success {
+		cb.failures++
+	}
+
+	if cb.total >= cb.minSamples && float64(cb.failures)/float64(cb.total) >= cb.ratio {
+		cb.setState(breakerOpen)
+		cb.openedAt = time.Now()
+	}
+}
+
+// isTransientStatus reports whether an HTTP status code should be
+// retried, namely the server-error range.
+func isTransientStatus(code int) bool {
+	return code >= 500 && code < 600
+}
+
+// backoff returns the delay before retry attempt n (0-indexed),
+// exponential with a base delay capped at 2 seconds, plus up to 50%
+// random jitter on top (so at most 3 seconds).
+func backoff(n int) time.Duration {
+	d := time.Duration(math.Pow(2, float64(n))) * 100 * time.Millisecond
+	if d > 2*time.Second {
+		d = 2 * time.Second
+	}
+	jitter := time.Duration(rand.Int63n(int64(d) / 2))
+	return d + jitter
+}
+
+// defaultEndpointTimeout is used by every transport when a rule doesn't
+// set endpoint_timeout, so no transport (HTTP or gRPC) can block a
+// request indefinitely on an unresponsive auth service.
+const defaultEndpointTimeout = 2 * time.Second
+
+// newRuleClient builds the per-rule HTTP client used for endpoint
+// calls, with its own timeout so a slow auth service can't stall every
+// request on the shared default client's zero timeout.
+func newRuleClient(timeout time.Duration) *http.Client {
+	if timeout <= 0 {
+		timeout = defaultEndpointTimeout
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			MaxIdleConnsPerHost: 20,
+		},
+		Timeout: timeout,
+	}
+}
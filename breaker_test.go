@@ -0,0 +1,102 @@
+package cauth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerTripsOnFailureRatio(t *testing.T) {
+	cb := newCircuitBreaker("test-trip", 0.5, time.Minute)
+	cb.minSamples = 4
+
+	for i := 0; i < 3; i++ {
+		if !cb.allow() {
+			t.Fatalf("call %d: expected closed breaker to allow", i)
+		}
+		cb.record(false)
+	}
+	if cb.state != breakerClosed {
+		t.Fatalf("expected breaker to stay closed below minSamples, got %v", cb.state)
+	}
+
+	cb.record(false)
+	if cb.state != breakerOpen {
+		t.Fatalf("expected breaker to open once failure ratio crosses threshold, got %v", cb.state)
+	}
+	if cb.allow() {
+		t.Fatalf("expected open breaker to reject calls before cooldown elapses")
+	}
+}
+
+func TestCircuitBreakerStaysClosedBelowRatio(t *testing.T) {
+	cb := newCircuitBreaker("test-ratio", 0.5, time.Minute)
+	cb.minSamples = 4
+
+	for i := 0; i < 10; i++ {
+		if !cb.allow() {
+			t.Fatalf("call %d: expected closed breaker to allow", i)
+		}
+		cb.record(i%4 != 0) // 1 in 4 failures, below the 0.5 ratio
+	}
+	if cb.state != breakerClosed {
+		t.Fatalf("expected breaker to remain closed, got %v", cb.state)
+	}
+}
+
+func TestCircuitBreakerHalfOpenAllowsOnlyOneTrial(t *testing.T) {
+	cb := newCircuitBreaker("test-half-open", 0.5, 10*time.Millisecond)
+	cb.minSamples = 1
+	cb.allow()
+	cb.record(false) // trips the breaker open
+
+	time.Sleep(15 * time.Millisecond)
+
+	if !cb.allow() {
+		t.Fatalf("expected the first caller after cooldown to get the trial call")
+	}
+	if cb.state != breakerHalfOpen {
+		t.Fatalf("expected breaker to be half-open after the trial is admitted, got %v", cb.state)
+	}
+
+	for i := 0; i < 5; i++ {
+		if cb.allow() {
+			t.Fatalf("expected concurrent callers to be rejected while a trial is in flight")
+		}
+	}
+}
+
+func TestCircuitBreakerHalfOpenSuccessCloses(t *testing.T) {
+	cb := newCircuitBreaker("test-half-open-success", 0.5, 10*time.Millisecond)
+	cb.minSamples = 1
+	cb.allow()
+	cb.record(false) // open
+
+	time.Sleep(15 * time.Millisecond)
+	cb.allow() // admits the trial, moves to half-open
+	cb.record(true)
+
+	if cb.state != breakerClosed {
+		t.Fatalf("expected a successful trial to close the breaker, got %v", cb.state)
+	}
+	if !cb.allow() {
+		t.Fatalf("expected closed breaker to allow calls again")
+	}
+}
+
+func TestCircuitBreakerHalfOpenFailureReopens(t *testing.T) {
+	cb := newCircuitBreaker("test-half-open-failure", 0.5, 10*time.Millisecond)
+	cb.minSamples = 1
+	cb.allow()
+	cb.record(false) // open
+
+	time.Sleep(15 * time.Millisecond)
+	cb.allow() // admits the trial, moves to half-open
+	cb.record(false)
+
+	if cb.state != breakerOpen {
+		t.Fatalf("expected a failed trial to reopen the breaker, got %v", cb.state)
+	}
+	if cb.allow() {
+		t.Fatalf("expected the reopened breaker to reject calls immediately")
+	}
+}
@@ -0,0 +1,137 @@
+package cauth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cacheEntry holds a cached auth decision for a rule.
+type cacheEntry struct {
+	headers map[string]string
+	claims  map[string]interface{}
+	code    int
+	expires time.Time
+}
+
+// defaultCacheMax bounds a rule's cache when cache_ttl is set without an
+// explicit cache_max. Negative caching is meant to blunt a
+// credential-stuffing storm, but each distinct (and likely invalid)
+// credential produces its own cache key; left unbounded, that storm
+// just becomes a memory-exhaustion vector instead.
+const defaultCacheMax = 10000
+
+// ruleCache is a small in-process, size-bounded cache of auth endpoint
+// decisions for a single rule. It is deliberately simple: entries are
+// evicted at random once the cache is full rather than tracking LRU
+// order, since auth endpoint responses are cheap to recompute and the
+// goal is just to avoid a storm of round-trips for the same credentials.
+type ruleCache struct {
+	mu      sync.Mutex
+	max     int
+	entries map[string]cacheEntry
+}
+
+func newRuleCache(max int) *ruleCache {
+	if max <= 0 {
+		max = defaultCacheMax
+	}
+	return &ruleCache{
+		max:     max,
+		entries: make(map[string]cacheEntry),
+	}
+}
+
+func (rc *ruleCache) get(key string) (cacheEntry, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	e, ok := rc.entries[key]
+	if !ok {
+		return cacheEntry{}, false
+	}
+	if time.Now().After(e.expires) {
+		delete(rc.entries, key)
+		return cacheEntry{}, false
+	}
+	return e, true
+}
+
+func (rc *ruleCache) set(key string, e cacheEntry) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.max > 0 && len(rc.entries) >= rc.max {
+		if _, exists := rc.entries[key]; !exists {
+			// evict an arbitrary entry to make room; map iteration
+			// order is randomized in Go so this is good enough.
+			for k := range rc.entries {
+				delete(rc.entries, k)
+				break
+			}
+		}
+	}
+	rc.entries[key] = e
+}
+
+// cacheKey builds the cache key for an auth request. If the rule
+// configures specific cache_key fields, only those fields participate
+// in the key; otherwise the full request payload is hashed.
+func cacheKey(rule Rule, data *authReq) string {
+	h := sha256.New()
+	if len(rule.CacheKeyFields) == 0 {
+		for _, k := range sortedKeys(data.Headers) {
+			h.Write([]byte("h:" + k + "=" + data.Headers[k] + "\n"))
+		}
+		for _, k := range sortedKeys(data.Queries) {
+			h.Write([]byte("q:" + k + "=" + data.Queries[k] + "\n"))
+		}
+		return hex.EncodeToString(h.Sum(nil))
+	}
+	for _, field := range rule.CacheKeyFields {
+		kind, name, ok := strings.Cut(field, ":")
+		if !ok {
+			continue
+		}
+		switch kind {
+		case "header":
+			h.Write([]byte("h:" + name + "=" + data.Headers[name] + "\n"))
+		case "query":
+			h.Write([]byte("q:" + name + "=" + data.Queries[name] + "\n"))
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// isNoCacheStatus reports whether code is in the rule's no_cache_status
+// list and should therefore never be stored in the cache.
+func isNoCacheStatus(rule Rule, code int) bool {
+	for _, c := range rule.NoCacheStatus {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// ttlFor returns the TTL that should be used to cache a response with
+// the given status code: the shorter NegativeCacheTTL for 401/403
+// responses (to bound how long a credential-stuffing storm can be
+// amplified), and CacheTTL otherwise.
+func ttlFor(rule Rule, code int) time.Duration {
+	if (code == http.StatusUnauthorized || code == http.StatusForbidden) && rule.NegativeCacheTTL > 0 {
+		return rule.NegativeCacheTTL
+	}
+	return rule.CacheTTL
+}
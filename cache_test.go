@@ -0,0 +1,106 @@
+package cauth
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCacheKeyStableForSameInput(t *testing.T) {
+	rule := Rule{}
+	data := &authReq{Headers: map[string]string{"Authorization": "Bearer abc"}}
+
+	k1 := cacheKey(rule, data)
+	k2 := cacheKey(rule, data)
+	if k1 != k2 {
+		t.Fatalf("expected cacheKey to be deterministic, got %q and %q", k1, k2)
+	}
+}
+
+func TestCacheKeyDiffersOnDifferentCredentials(t *testing.T) {
+	rule := Rule{}
+	k1 := cacheKey(rule, &authReq{Headers: map[string]string{"Authorization": "Bearer abc"}})
+	k2 := cacheKey(rule, &authReq{Headers: map[string]string{"Authorization": "Bearer xyz"}})
+	if k1 == k2 {
+		t.Fatalf("expected different credentials to produce different cache keys")
+	}
+}
+
+func TestCacheKeyHonorsCacheKeyFields(t *testing.T) {
+	rule := Rule{CacheKeyFields: []string{"header:Authorization"}}
+	data1 := &authReq{
+		Headers: map[string]string{"Authorization": "Bearer abc"},
+		Queries: map[string]string{"trace": "one"},
+	}
+	data2 := &authReq{
+		Headers: map[string]string{"Authorization": "Bearer abc"},
+		Queries: map[string]string{"trace": "two"},
+	}
+	if cacheKey(rule, data1) != cacheKey(rule, data2) {
+		t.Fatalf("expected cache_key fields to ignore fields outside the list")
+	}
+}
+
+func TestTTLForUsesNegativeCacheTTLOnDenials(t *testing.T) {
+	rule := Rule{CacheTTL: time.Minute, NegativeCacheTTL: time.Second}
+
+	if got := ttlFor(rule, http.StatusOK); got != time.Minute {
+		t.Fatalf("expected CacheTTL for a 200, got %v", got)
+	}
+	if got := ttlFor(rule, http.StatusUnauthorized); got != time.Second {
+		t.Fatalf("expected NegativeCacheTTL for a 401, got %v", got)
+	}
+	if got := ttlFor(rule, http.StatusForbidden); got != time.Second {
+		t.Fatalf("expected NegativeCacheTTL for a 403, got %v", got)
+	}
+}
+
+func TestTTLForFallsBackToCacheTTLWithoutNegativeCacheTTL(t *testing.T) {
+	rule := Rule{CacheTTL: time.Minute}
+	if got := ttlFor(rule, http.StatusUnauthorized); got != time.Minute {
+		t.Fatalf("expected CacheTTL when NegativeCacheTTL is unset, got %v", got)
+	}
+}
+
+func TestIsNoCacheStatus(t *testing.T) {
+	rule := Rule{NoCacheStatus: []int{429, 503}}
+	if !isNoCacheStatus(rule, 503) {
+		t.Fatalf("expected 503 to be a no-cache status")
+	}
+	if isNoCacheStatus(rule, 200) {
+		t.Fatalf("expected 200 not to be a no-cache status")
+	}
+}
+
+func TestNewRuleCacheDefaultsMaxWhenUnset(t *testing.T) {
+	rc := newRuleCache(0)
+	if rc.max != defaultCacheMax {
+		t.Fatalf("expected newRuleCache(0) to default max to %d, got %d", defaultCacheMax, rc.max)
+	}
+}
+
+func TestRuleCacheEvictsOnceFull(t *testing.T) {
+	rc := newRuleCache(2)
+	rc.set("a", cacheEntry{code: 200, expires: time.Now().Add(time.Minute)})
+	rc.set("b", cacheEntry{code: 200, expires: time.Now().Add(time.Minute)})
+	rc.set("c", cacheEntry{code: 200, expires: time.Now().Add(time.Minute)})
+
+	if len(rc.entries) != 2 {
+		t.Fatalf("expected ruleCache to stay bounded at max=2, got %d entries", len(rc.entries))
+	}
+	if _, ok := rc.get("c"); !ok {
+		t.Fatalf("expected the newest entry to survive eviction")
+	}
+}
+
+func TestRuleCacheGetExpiresEntries(t *testing.T) {
+	rc := newRuleCache(10)
+	rc.set("a", cacheEntry{code: 200, expires: time.Now().Add(-time.Second)})
+
+	if _, ok := rc.get("a"); ok {
+		t.Fatalf("expected an expired entry to be evicted on get")
+	}
+	if _, ok := rc.entries["a"]; ok {
+		t.Fatalf("expected get to delete the expired entry from the map")
+	}
+}
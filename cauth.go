@@ -1,13 +1,10 @@
 package cauth
 
 import (
-	"bytes"
-	"encoding/json"
-	"io/ioutil"
 	"net/http"
-	"net/url"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/caddyserver/caddy/caddyhttp/httpserver"
 	"github.com/pkg/errors"
@@ -18,6 +15,17 @@ type authReq struct {
 	Queries map[string]string `json:"queries"`
 }
 
+// authResp is the shape of a 200 response from the auth endpoint since
+// chunk0-3: {"headers": {...}, "claims": {...}}, where claims feeds the
+// policy stage (see policy.go). For compatibility with endpoints
+// written before chunk0-3, decodeAuthResponse (transport.go) also
+// accepts the original flat {"X-Header": "value"} shape and treats it
+// as Headers with no claims.
+type authResp struct {
+	Headers map[string]string      `json:"headers"`
+	Claims  map[string]interface{} `json:"claims"`
+}
+
 func (h *CAuth) ServeHTTP(w http.ResponseWriter, r *http.Request) (int, error) {
 	for _, p := range h.Rules {
 		cleanedPath := path.Clean(r.URL.Path)
@@ -44,6 +52,35 @@ func (h *CAuth) ServeHTTP(w http.ResponseWriter, r *http.Request) (int, error) {
 			continue
 		}
 
+		// A rule configured with a JWKS URL is authorized by validating
+		// the bearer token locally instead of going through the
+		// header/query extraction and endpoint callout below.
+		if p.JWKSURL != "" {
+			start := time.Now()
+			headers, claims, code, err := verifyJWT(r, p)
+			latency := time.Since(start)
+			if err != nil || code != http.StatusOK {
+				if p.Passthrough {
+					logDecision(p, "passthrough", code, latency, nil)
+					continue
+				}
+				logDecision(p, "deny", code, latency, nil)
+				return handleUnauthorized(w, r, p, code), nil
+			}
+			if p.policy != nil {
+				allowed, err := evalPolicy(p.policy, r, headers, claims)
+				if err != nil || !allowed {
+					logDecision(p, "deny", http.StatusForbidden, latency, nil)
+					return handleForbidden(w, r, p), nil
+				}
+			}
+			logDecision(p, "allow", code, latency, nil)
+			for k, v := range headers {
+				r.Header.Set(k, v)
+			}
+			return h.Next.ServeHTTP(w, r)
+		}
+
 		epData := authReq{
 			Headers: map[string]string{},
 			Queries: map[string]string{},
@@ -93,19 +130,46 @@ func (h *CAuth) ServeHTTP(w http.ResponseWriter, r *http.Request) (int, error) {
 		}
 		if missingRequiredField {
 			if p.Passthrough {
+				logDecision(p, "passthrough", 0, 0, &epData)
 				continue
 			}
+			logDecision(p, "deny", 401, 0, &epData)
 			return handleUnauthorized(w, r, p, 401), nil
 		}
 
 		// Path matches, authorize
-		headers, code, err := callEndpoint(r, p, &epData)
+		start := time.Now()
+		headers, claims, code, err := callEndpoint(r, p, &epData)
+		latency := time.Since(start)
+		if err == errBreakerOpen {
+			switch p.BreakerFallback {
+			case "allow":
+				logDecision(p, "allow", http.StatusOK, latency, &epData)
+				return h.Next.ServeHTTP(w, r)
+			case "passthrough":
+				logDecision(p, "passthrough", code, latency, &epData)
+				continue
+			default: // "deny", or unset
+				logDecision(p, "deny", http.StatusServiceUnavailable, latency, &epData)
+				return handleUnauthorized(w, r, p, http.StatusServiceUnavailable), nil
+			}
+		}
 		if err != nil || code != 200 {
 			if p.Passthrough {
+				logDecision(p, "passthrough", code, latency, &epData)
 				continue
 			}
+			logDecision(p, "deny", code, latency, &epData)
 			return handleUnauthorized(w, r, p, code), nil
 		}
+		if p.policy != nil {
+			allowed, err := evalPolicy(p.policy, r, headers, claims)
+			if err != nil || !allowed {
+				logDecision(p, "deny", http.StatusForbidden, latency, &epData)
+				return handleForbidden(w, r, p), nil
+			}
+		}
+		logDecision(p, "allow", code, latency, &epData)
 		for k, v := range headers {
 			r.Header.Set(k, v)
 		}
@@ -115,41 +179,83 @@ func (h *CAuth) ServeHTTP(w http.ResponseWriter, r *http.Request) (int, error) {
 	return h.Next.ServeHTTP(w, r)
 }
 
-func callEndpoint(r *http.Request, rule Rule, data *authReq) (headers map[string]string, code int, err error) {
-	req := &http.Request{}
-	req.URL, err = url.Parse(rule.Endpoint)
-	if err != nil {
-		err = errors.Wrap(err, "couldn't parse endpoint")
-		return
-	}
-	req.Method = "POST"
+// errBreakerOpen is returned by callEndpoint when the rule's circuit
+// breaker is open; ServeHTTP interprets it using rule.BreakerFallback
+// rather than treating it like an ordinary auth denial.
+var errBreakerOpen = errors.New("circuit breaker open")
 
-	var body []byte
-	body, err = json.Marshal(data)
-	if err != nil {
-		err = errors.Wrap(err, "couldn't marshal headers")
-		return
+func callEndpoint(r *http.Request, rule Rule, data *authReq) (headers map[string]string, claims map[string]interface{}, code int, err error) {
+	var key string
+	if rule.cache != nil {
+		key = cacheKey(rule, data)
+		if entry, ok := rule.cache.get(key); ok {
+			cacheHitsTotal.WithLabelValues(rule.Path).Inc()
+			return entry.headers, entry.claims, entry.code, nil
+		}
 	}
-	req.Body = ioutil.NopCloser(bytes.NewReader(body))
-	var res *http.Response
-	res, err = httpCli.Do(req)
-	if err != nil {
-		err = errors.Wrap(err, "error contacting endpoint")
-		return
+
+	if rule.breaker != nil && !rule.breaker.allow() {
+		return nil, nil, 0, errBreakerOpen
 	}
-	code = res.StatusCode
-	if res.StatusCode != 200 {
-		err = errors.New("Not authorized")
-		return
+
+	start := time.Now()
+	headers, claims, code, err = callEndpointWithRetry(r, rule, data)
+	endpointDuration.WithLabelValues(rule.Path).Observe(time.Since(start).Seconds())
+
+	if rule.breaker != nil {
+		rule.breaker.record(!isEndpointFailure(code, err))
 	}
-	err = json.NewDecoder(res.Body).Decode(&headers)
-	if err != nil {
-		err = errors.Wrap(err, "couldn't decode response")
-		return
+
+	// A 200 is cached only when it decoded cleanly; a denial (401/403
+	// and other non-5xx statuses) is cached too, which is what gives
+	// NegativeCacheTTL effect. Transport errors and 5xx are never cached.
+	cacheable := err == nil || (code != 0 && code != http.StatusOK && !isTransientStatus(code))
+	if rule.cache != nil && cacheable && !isNoCacheStatus(rule, code) {
+		ttl := ttlFor(rule, code)
+		if ttl > 0 {
+			rule.cache.set(key, cacheEntry{
+				headers: headers,
+				claims:  claims,
+				code:    code,
+				expires: time.Now().Add(ttl),
+			})
+		}
 	}
 	return
 }
 
+// callEndpointWithRetry retries doCallEndpoint with exponential backoff
+// for transient failures (transport errors and 5xx responses), up to
+// rule.EndpointRetries additional attempts.
+func callEndpointWithRetry(r *http.Request, rule Rule, data *authReq) (headers map[string]string, claims map[string]interface{}, code int, err error) {
+	for attempt := 0; ; attempt++ {
+		headers, claims, code, err = doCallEndpoint(r, rule, data)
+		if !isEndpointFailure(code, err) || attempt >= rule.EndpointRetries {
+			return
+		}
+		time.Sleep(backoff(attempt))
+	}
+}
+
+// isEndpointFailure reports whether a call outcome represents an
+// endpoint health problem, as opposed to an ordinary auth denial such
+// as a 401/403: either the request never got a response (transport
+// error, code still 0) or the endpoint returned a 5xx.
+func isEndpointFailure(code int, err error) bool {
+	return (err != nil && code == 0) || isTransientStatus(code)
+}
+
+// doCallEndpoint dispatches to the rule's Transport (JSON by default,
+// see transport.go/grpc.go), falling back to the original JSON
+// transport if none was built at parse time.
+func doCallEndpoint(r *http.Request, rule Rule, data *authReq) (headers map[string]string, claims map[string]interface{}, code int, err error) {
+	t := rule.transport
+	if t == nil {
+		t = jsonTransport{}
+	}
+	return t.Call(r, rule, data)
+}
+
 // handleUnauthorized checks, which action should be performed if access was denied.
 // It returns the status code and writes the Location header in case of a redirect.
 // Possible caddy variables in the location value will be substituted.
@@ -3,9 +3,12 @@ package cauth
 import (
 	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/caddyserver/caddy"
 	"github.com/caddyserver/caddy/caddyhttp/httpserver"
+	"github.com/expr-lang/expr/vm"
 )
 
 var httpCli *http.Client
@@ -35,6 +38,59 @@ type Rule struct {
 	Passthrough     bool
 	StripHeader     bool
 	Endpoint        string
+
+	// Response caching, see cache.go.
+	CacheTTL         time.Duration
+	CacheMax         int
+	CacheKeyFields   []string
+	NegativeCacheTTL time.Duration
+	NoCacheStatus    []int
+
+	cache *ruleCache
+
+	// Local JWT/JWKS verification, see jwt.go. An alternative to
+	// Endpoint: when JWKSURL is set, the rule is authorized by
+	// validating the bearer token locally instead of calling out.
+	JWKSURL      string
+	JWKSRefresh  time.Duration
+	Issuer       string
+	Audience     string
+	ClaimHeaders map[string]string
+
+	jwks *jwksCache
+
+	// Policy is a post-authorization expression evaluated against the
+	// claims/headers returned by authorization and the inbound
+	// request; see policy.go. Compiled once into policy at parse time.
+	Policy string
+
+	policy *vm.Program
+
+	// Per-rule endpoint resiliency: timeout/retries on the HTTP call,
+	// and a circuit breaker that stops calling out once the endpoint
+	// is clearly unhealthy. See breaker.go.
+	EndpointTimeout time.Duration
+	EndpointRetries int
+	BreakerRatio    float64
+	BreakerWindow   time.Duration
+	BreakerFallback string
+
+	client  *http.Client
+	breaker *circuitBreaker
+
+	// LogLevel controls how verbosely this rule's decisions are logged:
+	// one of "debug", "info" (default), "error" or "off". See logging.go.
+	LogLevel string
+
+	// EndpointType selects how the authorization decision is obtained:
+	// "json" (default, the original bespoke protocol), "forward_auth"
+	// or "grpc". CopyHeaders lists the response headers forward_auth
+	// copies back onto the downstream request. See transport.go/grpc.go.
+	EndpointType string
+	CopyHeaders  []string
+	GRPCInsecure bool
+
+	transport Transport
 }
 
 func init() {
@@ -58,7 +114,7 @@ func setup(c *caddy.Controller) error {
 	}
 
 	c.OnStartup(func() error {
-		fmt.Println("Custom Auth middleware is initiated")
+		logEvent(logEntry{Level: "info", Message: "Custom Auth middleware is initiated"})
 		return nil
 	})
 
@@ -184,11 +240,202 @@ func parse(c *caddy.Controller) ([]Rule, error) {
 					r.Passthrough = true
 				case "strip_header":
 					r.StripHeader = true
+				case "cache_ttl":
+					if !c.NextArg() {
+						return nil, c.ArgErr()
+					}
+					d, err := time.ParseDuration(c.Val())
+					if err != nil {
+						return nil, c.Err(err.Error())
+					}
+					r.CacheTTL = d
+				case "cache_max":
+					if !c.NextArg() {
+						return nil, c.ArgErr()
+					}
+					n, err := strconv.Atoi(c.Val())
+					if err != nil {
+						return nil, c.Err(err.Error())
+					}
+					r.CacheMax = n
+				case "cache_key":
+					if !c.NextArg() {
+						return nil, c.ArgErr()
+					}
+					r.CacheKeyFields = append(r.CacheKeyFields, c.Val())
+				case "negative_cache_ttl":
+					if !c.NextArg() {
+						return nil, c.ArgErr()
+					}
+					d, err := time.ParseDuration(c.Val())
+					if err != nil {
+						return nil, c.Err(err.Error())
+					}
+					r.NegativeCacheTTL = d
+				case "no_cache_status":
+					args1 := c.RemainingArgs()
+					if len(args1) == 0 {
+						return nil, c.ArgErr()
+					}
+					for _, a := range args1 {
+						n, err := strconv.Atoi(a)
+						if err != nil {
+							return nil, c.Err(err.Error())
+						}
+						r.NoCacheStatus = append(r.NoCacheStatus, n)
+					}
+				case "jwks":
+					if !c.NextArg() {
+						return nil, c.ArgErr()
+					}
+					r.JWKSURL = c.Val()
+				case "jwks_refresh":
+					if !c.NextArg() {
+						return nil, c.ArgErr()
+					}
+					d, err := time.ParseDuration(c.Val())
+					if err != nil {
+						return nil, c.Err(err.Error())
+					}
+					r.JWKSRefresh = d
+				case "issuer":
+					if !c.NextArg() {
+						return nil, c.ArgErr()
+					}
+					r.Issuer = c.Val()
+				case "audience":
+					if !c.NextArg() {
+						return nil, c.ArgErr()
+					}
+					r.Audience = c.Val()
+				case "claim_header":
+					if !c.NextArg() {
+						return nil, c.ArgErr()
+					}
+					claim := c.Val()
+					if !c.NextArg() {
+						return nil, c.ArgErr()
+					}
+					header := c.Val()
+					if r.ClaimHeaders == nil {
+						r.ClaimHeaders = map[string]string{}
+					}
+					r.ClaimHeaders[claim] = header
+				case "policy":
+					if !c.NextArg() {
+						return nil, c.ArgErr()
+					}
+					r.Policy = c.Val()
+					prog, err := compilePolicy(r.Policy)
+					if err != nil {
+						return nil, c.Err(err.Error())
+					}
+					r.policy = prog
+				case "endpoint_timeout":
+					if !c.NextArg() {
+						return nil, c.ArgErr()
+					}
+					d, err := time.ParseDuration(c.Val())
+					if err != nil {
+						return nil, c.Err(err.Error())
+					}
+					r.EndpointTimeout = d
+				case "endpoint_retries":
+					if !c.NextArg() {
+						return nil, c.ArgErr()
+					}
+					n, err := strconv.Atoi(c.Val())
+					if err != nil {
+						return nil, c.Err(err.Error())
+					}
+					r.EndpointRetries = n
+				case "breaker_ratio":
+					if !c.NextArg() {
+						return nil, c.ArgErr()
+					}
+					f, err := strconv.ParseFloat(c.Val(), 64)
+					if err != nil {
+						return nil, c.Err(err.Error())
+					}
+					r.BreakerRatio = f
+				case "breaker_window":
+					if !c.NextArg() {
+						return nil, c.ArgErr()
+					}
+					d, err := time.ParseDuration(c.Val())
+					if err != nil {
+						return nil, c.Err(err.Error())
+					}
+					r.BreakerWindow = d
+				case "breaker_fallback":
+					if !c.NextArg() {
+						return nil, c.ArgErr()
+					}
+					switch c.Val() {
+					case "allow", "deny", "passthrough":
+						r.BreakerFallback = c.Val()
+					default:
+						return nil, c.Err("breaker_fallback must be one of allow, deny, passthrough")
+					}
+				case "log_level":
+					if !c.NextArg() {
+						return nil, c.ArgErr()
+					}
+					switch c.Val() {
+					case "debug", "info", "error", "off":
+						r.LogLevel = c.Val()
+					default:
+						return nil, c.Err("log_level must be one of debug, info, error, off")
+					}
+				case "endpoint_type":
+					if !c.NextArg() {
+						return nil, c.ArgErr()
+					}
+					switch c.Val() {
+					case "json", "forward_auth", "grpc":
+						r.EndpointType = c.Val()
+					default:
+						return nil, c.Err("endpoint_type must be one of json, forward_auth, grpc")
+					}
+				case "copy_headers":
+					args1 := c.RemainingArgs()
+					if len(args1) == 0 {
+						return nil, c.ArgErr()
+					}
+					r.CopyHeaders = append(r.CopyHeaders, args1...)
+				case "grpc_insecure":
+					r.GRPCInsecure = true
+				}
+			}
+			if r.CacheTTL > 0 {
+				r.cache = newRuleCache(r.CacheMax)
+			}
+			if r.JWKSURL != "" {
+				if r.JWKSRefresh == 0 {
+					r.JWKSRefresh = 5 * time.Minute
 				}
+				r.jwks = newJWKSCache(r.JWKSURL, r.JWKSRefresh)
+			}
+			r.client = newRuleClient(r.EndpointTimeout)
+			if r.BreakerRatio > 0 {
+				window := r.BreakerWindow
+				if window == 0 {
+					window = 30 * time.Second
+				}
+				r.breaker = newCircuitBreaker(r.Path, r.BreakerRatio, window)
+			}
+			if r.Endpoint != "" {
+				t, terr := newTransport(r)
+				if terr != nil {
+					return nil, terr
+				}
+				r.transport = t
 			}
 			rules = append(rules, r)
 		case 1:
-			rules = append(rules, Rule{Path: args[0]})
+			r := Rule{Path: args[0]}
+			r.client = newRuleClient(r.EndpointTimeout)
+			rules = append(rules, r)
 			// one argument passed
 			if c.NextBlock() {
 				// path specified, no block required.
@@ -0,0 +1,100 @@
+package cauth
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	rpccode "google.golang.org/genproto/googleapis/rpc/code"
+	rpcstatus "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// fakeExtAuthzServer is a minimal Envoy ext_authz v3 server: it grants
+// when the inbound request carries X-Allow: yes, and denies otherwise.
+type fakeExtAuthzServer struct {
+	authv3.UnimplementedAuthorizationServer
+}
+
+func (fakeExtAuthzServer) Check(ctx context.Context, req *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+	headers := req.GetAttributes().GetRequest().GetHttp().GetHeaders()
+	if headers["x-allow"] != "yes" {
+		return &authv3.CheckResponse{
+			Status: &rpcstatus.Status{Code: int32(rpccode.Code_PERMISSION_DENIED)},
+			HttpResponse: &authv3.CheckResponse_DeniedResponse{
+				DeniedResponse: &authv3.DeniedHttpResponse{
+					Status: &typev3.HttpStatus{Code: typev3.StatusCode_Forbidden},
+				},
+			},
+		}, nil
+	}
+	return &authv3.CheckResponse{
+		Status: &rpcstatus.Status{Code: int32(rpccode.Code_OK)},
+		HttpResponse: &authv3.CheckResponse_OkResponse{
+			OkResponse: &authv3.OkHttpResponse{
+				Headers: []*corev3.HeaderValueOption{
+					{Header: &corev3.HeaderValue{Key: "X-User", Value: "dana"}},
+				},
+			},
+		},
+	}, nil
+}
+
+func dialFakeExtAuthz(t *testing.T, srv authv3.AuthorizationServer) *grpc.ClientConn {
+	t.Helper()
+	lis := bufconn.Listen(1024 * 1024)
+	gs := grpc.NewServer()
+	authv3.RegisterAuthorizationServer(gs, srv)
+	go gs.Serve(lis)
+	t.Cleanup(gs.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.Dial()
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestGRPCTransportCallAllows(t *testing.T) {
+	conn := dialFakeExtAuthz(t, fakeExtAuthzServer{})
+	tr := &grpcTransport{client: authv3.NewAuthorizationClient(conn), timeout: time.Second}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	headers, _, code, err := tr.Call(r, Rule{}, &authReq{Headers: map[string]string{"X-Allow": "yes"}})
+	if err != nil {
+		t.Fatalf("expected the allow case not to error: %v", err)
+	}
+	if code != 200 {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if headers["X-User"] != "dana" {
+		t.Fatalf("expected X-User=dana from the ext_authz response, got %v", headers)
+	}
+}
+
+func TestGRPCTransportCallDenies(t *testing.T) {
+	conn := dialFakeExtAuthz(t, fakeExtAuthzServer{})
+	tr := &grpcTransport{client: authv3.NewAuthorizationClient(conn), timeout: time.Second}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	_, _, code, err := tr.Call(r, Rule{}, &authReq{})
+	if err == nil {
+		t.Fatalf("expected the deny case to error")
+	}
+	if code != 403 {
+		t.Fatalf("expected the denied response's status to surface as 403, got %d", code)
+	}
+}
@@ -0,0 +1,314 @@
+package cauth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v4"
+	"github.com/pkg/errors"
+)
+
+// jwk is a single entry of a JSON Web Key Set, as returned by a JWKS
+// endpoint. Only the fields needed to reconstruct RSA, EC and HMAC
+// keys are decoded.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	Crv string `json:"crv"`
+	K   string `json:"k"`
+}
+
+type jwkSet struct {
+	Keys []jwk `json:"keys"`
+}
+
+// jwkKey is a parsed JWK together with the kty/alg it was declared
+// under, so keyFor can reject a key being used for a signing method it
+// wasn't published for (see checkAlg).
+type jwkKey struct {
+	key interface{}
+	kty string
+	alg string
+}
+
+// jwksCache fetches and caches the public keys served by a rule's JWKS
+// URL, refreshing them periodically and reusing the ETag to avoid
+// re-downloading and re-parsing keys that haven't changed.
+type jwksCache struct {
+	mu        sync.Mutex // guards keys/etag/fetchedAt
+	refreshMu sync.Mutex // serializes refreshes; never held during keyFor's cache reads
+	url       string
+	refresh   time.Duration
+	etag      string
+	keys      map[string]jwkKey
+	fetchedAt time.Time
+}
+
+func newJWKSCache(url string, refresh time.Duration) *jwksCache {
+	return &jwksCache{
+		url:     url,
+		refresh: refresh,
+		keys:    make(map[string]jwkKey),
+	}
+}
+
+// keyFor returns the public (or shared, for HS*) key for the given kid,
+// refreshing the key set from the JWKS URL if it is stale, and verifies
+// alg (the token's signing algorithm) is one the key was published for.
+func (jc *jwksCache) keyFor(kid, alg string) (interface{}, error) {
+	jc.mu.Lock()
+	stale := time.Since(jc.fetchedAt) > jc.refresh || len(jc.keys) == 0
+	jc.mu.Unlock()
+
+	if stale {
+		if err := jc.refreshKeys(); err != nil {
+			jc.mu.Lock()
+			haveKeys := len(jc.keys) != 0
+			jc.mu.Unlock()
+			if !haveKeys {
+				return nil, err
+			}
+		}
+	}
+
+	jc.mu.Lock()
+	entry, ok := jc.keys[kid]
+	jc.mu.Unlock()
+	if !ok {
+		return nil, errors.Errorf("no key found for kid %q", kid)
+	}
+	if err := checkAlg(alg, entry); err != nil {
+		return nil, err
+	}
+	return entry.key, nil
+}
+
+// checkAlg rejects a key being used under a signing algorithm it wasn't
+// published for. Without this, a key published as (say) an RSA
+// verification key could be handed back for an attacker-chosen HS256
+// token, letting them forge a signature with the RSA public key/modulus
+// as the HMAC secret (the classic alg-confusion attack).
+func checkAlg(alg string, entry jwkKey) error {
+	if entry.alg != "" && entry.alg != alg {
+		return errors.Errorf("token alg %q doesn't match jwk alg %q", alg, entry.alg)
+	}
+	var wantKty string
+	switch {
+	case strings.HasPrefix(alg, "RS"), strings.HasPrefix(alg, "PS"):
+		wantKty = "RSA"
+	case strings.HasPrefix(alg, "ES"):
+		wantKty = "EC"
+	case strings.HasPrefix(alg, "HS"):
+		wantKty = "oct"
+	default:
+		return errors.Errorf("unsupported signing algorithm %q", alg)
+	}
+	if entry.kty != wantKty {
+		return errors.Errorf("token alg %q isn't valid for a %q key", alg, entry.kty)
+	}
+	return nil
+}
+
+// refreshKeys re-fetches the JWKS document. refreshMu serializes
+// refreshes so a stampede of requests against a stale cache triggers one
+// HTTP round trip rather than one per request, but it is never held at
+// the same time as mu for longer than a map/field read: keyFor's
+// readers are never blocked on the network call, only on each other.
+func (jc *jwksCache) refreshKeys() error {
+	jc.refreshMu.Lock()
+	defer jc.refreshMu.Unlock()
+
+	jc.mu.Lock()
+	stillStale := time.Since(jc.fetchedAt) > jc.refresh || len(jc.keys) == 0
+	etag := jc.etag
+	jc.mu.Unlock()
+	if !stillStale {
+		// Another goroutine already refreshed while we waited on refreshMu.
+		return nil
+	}
+
+	req, err := http.NewRequest("GET", jc.url, nil)
+	if err != nil {
+		return errors.Wrap(err, "couldn't build jwks request")
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	res, err := httpCli.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "couldn't fetch jwks")
+	}
+	defer res.Body.Close()
+
+	fetchedAt := time.Now()
+	if res.StatusCode == http.StatusNotModified {
+		jc.mu.Lock()
+		jc.fetchedAt = fetchedAt
+		jc.mu.Unlock()
+		return nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return errors.Errorf("jwks endpoint returned status %d", res.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return errors.Wrap(err, "couldn't read jwks body")
+	}
+	var set jwkSet
+	if err := json.Unmarshal(body, &set); err != nil {
+		return errors.Wrap(err, "couldn't decode jwks")
+	}
+
+	keys := make(map[string]jwkKey, len(set.Keys))
+	for _, k := range set.Keys {
+		key, err := parseJWK(k)
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = jwkKey{key: key, kty: k.Kty, alg: k.Alg}
+	}
+
+	jc.mu.Lock()
+	jc.keys = keys
+	jc.fetchedAt = fetchedAt
+	if etag := res.Header.Get("ETag"); etag != "" {
+		jc.etag = etag
+	}
+	jc.mu.Unlock()
+	return nil
+}
+
+func parseJWK(k jwk) (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64URLDecode(k.N)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid RSA modulus")
+		}
+		e, err := base64URLDecode(k.E)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid RSA exponent")
+		}
+		eInt := 0
+		for _, b := range e {
+			eInt = eInt<<8 | int(b)
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: eInt,
+		}, nil
+	case "EC":
+		x, err := base64URLDecode(k.X)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid EC x coordinate")
+		}
+		y, err := base64URLDecode(k.Y)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid EC y coordinate")
+		}
+		curve, err := ecdsaCurve(k.Crv)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	case "oct":
+		secret, err := base64URLDecode(k.K)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid HMAC key")
+		}
+		return secret, nil
+	default:
+		return nil, errors.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func base64URLDecode(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(s)
+}
+
+// verifyJWT validates the bearer token on r against rule's JWKS, checks
+// the standard exp/nbf/iss/aud claims, and maps the configured claims
+// to downstream request headers. It returns the same (headers, claims,
+// code, err) shape as callEndpoint so ServeHTTP can treat both paths alike.
+func verifyJWT(r *http.Request, rule Rule) (headers map[string]string, claims map[string]interface{}, code int, err error) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return nil, nil, http.StatusUnauthorized, errors.New("missing bearer token")
+	}
+	raw := strings.TrimPrefix(auth, "Bearer ")
+
+	token, err := jwt.Parse(raw, func(t *jwt.Token) (interface{}, error) {
+		switch t.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA, *jwt.SigningMethodHMAC:
+		default:
+			return nil, errors.Errorf("unsupported signing method %v", t.Header["alg"])
+		}
+		kid, _ := t.Header["kid"].(string)
+		if kid == "" {
+			return nil, errors.New("token has no kid header")
+		}
+		// keyFor enforces that the key it returns was actually published
+		// for t.Method.Alg(), so a JWK meant for one algorithm can't be
+		// replayed against a token claiming a different one.
+		return rule.jwks.keyFor(kid, t.Method.Alg())
+	})
+	if err != nil || !token.Valid {
+		return nil, nil, http.StatusUnauthorized, errors.Wrap(err, "invalid token")
+	}
+
+	mapClaims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, nil, http.StatusUnauthorized, errors.New("unexpected claims type")
+	}
+	if rule.Issuer != "" && !mapClaims.VerifyIssuer(rule.Issuer, true) {
+		return nil, nil, http.StatusUnauthorized, errors.New("issuer mismatch")
+	}
+	if rule.Audience != "" && !mapClaims.VerifyAudience(rule.Audience, true) {
+		return nil, nil, http.StatusUnauthorized, errors.New("audience mismatch")
+	}
+	claims = mapClaims
+
+	headers = map[string]string{}
+	for claim, header := range rule.ClaimHeaders {
+		v, ok := claims[claim]
+		if !ok {
+			continue
+		}
+		headers[header] = fmt.Sprintf("%v", v)
+	}
+	return headers, claims, http.StatusOK, nil
+}
+
+func ecdsaCurve(crv string) (elliptic.Curve, error) {
+	switch crv {
+	case "P-256":
+		return elliptic.P256(), nil
+	case "P-384":
+		return elliptic.P384(), nil
+	case "P-521":
+		return elliptic.P521(), nil
+	default:
+		return nil, errors.Errorf("unsupported EC curve %q", crv)
+	}
+}
@@ -0,0 +1,205 @@
+package cauth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v4"
+)
+
+func b64url(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func rsaJWK(kid string, pub *rsa.PublicKey) jwk {
+	eBytes := big.NewInt(int64(pub.E)).Bytes()
+	return jwk{
+		Kty: "RSA",
+		Kid: kid,
+		Alg: "RS256",
+		N:   b64url(pub.N.Bytes()),
+		E:   b64url(eBytes),
+	}
+}
+
+func TestCheckAlgMatchesDeclaredAlg(t *testing.T) {
+	entry := jwkKey{kty: "RSA", alg: "RS256"}
+	if err := checkAlg("RS256", entry); err != nil {
+		t.Fatalf("expected RS256 to match a key declared for RS256: %v", err)
+	}
+}
+
+func TestCheckAlgRejectsMismatchedAlg(t *testing.T) {
+	entry := jwkKey{kty: "RSA", alg: "RS256"}
+	if err := checkAlg("RS384", entry); err == nil {
+		t.Fatalf("expected a declared alg mismatch (RS256 key, RS384 token) to be rejected")
+	}
+}
+
+func TestCheckAlgRejectsAlgConfusionAcrossKeyTypes(t *testing.T) {
+	// An RSA key (no declared alg) must never be accepted for an HMAC
+	// token - otherwise its public modulus could be replayed as an
+	// HS256 shared secret to forge a signature.
+	entry := jwkKey{kty: "RSA"}
+	if err := checkAlg("HS256", entry); err == nil {
+		t.Fatalf("expected an RSA key to be rejected for an HS256 token")
+	}
+}
+
+func TestCheckAlgRejectsUnsupportedAlg(t *testing.T) {
+	entry := jwkKey{kty: "oct"}
+	if err := checkAlg("none", entry); err == nil {
+		t.Fatalf("expected an unsupported alg to be rejected")
+	}
+}
+
+func TestParseJWKRSA(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := parseJWK(rsaJWK("kid1", &priv.PublicKey))
+	if err != nil {
+		t.Fatalf("parseJWK: %v", err)
+	}
+	pub, ok := key.(*rsa.PublicKey)
+	if !ok {
+		t.Fatalf("expected *rsa.PublicKey, got %T", key)
+	}
+	if pub.N.Cmp(priv.PublicKey.N) != 0 || pub.E != priv.PublicKey.E {
+		t.Fatalf("expected parsed key to match the original public key")
+	}
+}
+
+func TestParseJWKUnsupportedKty(t *testing.T) {
+	if _, err := parseJWK(jwk{Kty: "weird"}); err == nil {
+		t.Fatalf("expected an unsupported kty to error")
+	}
+}
+
+// jwksTestServer serves a single JWKS document and reports how many
+// times it has been fetched.
+func jwksTestServer(t *testing.T, set jwkSet) (*httptest.Server, *int) {
+	t.Helper()
+	fetches := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		body, _ := json.Marshal(set)
+		w.Write(body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &fetches
+}
+
+func TestVerifyJWTAcceptsValidToken(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, _ := jwksTestServer(t, jwkSet{Keys: []jwk{rsaJWK("kid1", &priv.PublicKey)}})
+
+	rule := Rule{
+		JWKSURL:      srv.URL,
+		Issuer:       "https://issuer.example",
+		Audience:     "my-api",
+		ClaimHeaders: map[string]string{"sub": "X-User"},
+		jwks:         newJWKSCache(srv.URL, time.Minute),
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"sub": "alice",
+		"iss": rule.Issuer,
+		"aud": rule.Audience,
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+	token.Header["kid"] = "kid1"
+	signed, err := token.SignedString(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer "+signed)
+
+	headers, claims, code, err := verifyJWT(r, rule)
+	if err != nil {
+		t.Fatalf("expected a valid token to verify, got err=%v code=%d", err, code)
+	}
+	if code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if headers["X-User"] != "alice" {
+		t.Fatalf("expected claim_header mapping to produce X-User=alice, got %q", headers["X-User"])
+	}
+	if claims["sub"] != "alice" {
+		t.Fatalf("expected claims to include sub")
+	}
+}
+
+func TestVerifyJWTRejectsWrongIssuer(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, _ := jwksTestServer(t, jwkSet{Keys: []jwk{rsaJWK("kid1", &priv.PublicKey)}})
+
+	rule := Rule{
+		JWKSURL: srv.URL,
+		Issuer:  "https://issuer.example",
+		jwks:    newJWKSCache(srv.URL, time.Minute),
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss": "https://someone-else.example",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+	token.Header["kid"] = "kid1"
+	signed, err := token.SignedString(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer "+signed)
+
+	if _, _, code, err := verifyJWT(r, rule); err == nil {
+		t.Fatalf("expected issuer mismatch to be rejected")
+	} else if code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", code)
+	}
+}
+
+func TestVerifyJWTRejectsMissingBearerToken(t *testing.T) {
+	rule := Rule{jwks: newJWKSCache("http://unused.example", time.Minute)}
+	r := httptest.NewRequest("GET", "/", nil)
+
+	if _, _, code, err := verifyJWT(r, rule); err == nil || code != http.StatusUnauthorized {
+		t.Fatalf("expected a missing bearer token to be rejected with 401, got code=%d err=%v", code, err)
+	}
+}
+
+func TestJWKSCacheRefreshesOnlyWhenStale(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, fetches := jwksTestServer(t, jwkSet{Keys: []jwk{rsaJWK("kid1", &priv.PublicKey)}})
+
+	jc := newJWKSCache(srv.URL, time.Minute)
+	if _, err := jc.keyFor("kid1", "RS256"); err != nil {
+		t.Fatalf("first keyFor: %v", err)
+	}
+	if _, err := jc.keyFor("kid1", "RS256"); err != nil {
+		t.Fatalf("second keyFor: %v", err)
+	}
+	if *fetches != 1 {
+		t.Fatalf("expected a single JWKS fetch while the cache is fresh, got %d", *fetches)
+	}
+}
@@ -0,0 +1,88 @@
+package cauth
+
+import (
+	"net/http"
+
+	"github.com/caddyserver/caddy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "cauth_requests_total",
+		Help: "Total number of requests evaluated by cauth, by rule, decision and upstream status code.",
+	}, []string{"rule", "decision", "code"})
+
+	endpointDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "cauth_endpoint_duration_seconds",
+		Help:    "Latency of auth endpoint callouts, by rule.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"rule"})
+
+	cacheHitsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "cauth_cache_hits_total",
+		Help: "Total number of auth decisions served from the response cache, by rule.",
+	}, []string{"rule"})
+
+	breakerStateGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cauth_breaker_state",
+		Help: "Current circuit breaker state by rule: 0=closed, 1=half-open, 2=open.",
+	}, []string{"rule"})
+)
+
+func init() {
+	prometheus.MustRegister(requestsTotal, endpointDuration, cacheHitsTotal, breakerStateGauge)
+
+	caddy.RegisterPlugin("cauth_metrics", caddy.Plugin{
+		ServerType: "http",
+		Action:     setupMetrics,
+	})
+}
+
+// metricsStarted guards against starting more than one side listener
+// when cauth_metrics appears in multiple server blocks.
+var metricsStarted bool
+
+func setupMetrics(c *caddy.Controller) error {
+	var listen, path string
+	for c.Next() {
+		for c.NextBlock() {
+			switch c.Val() {
+			case "listen":
+				if !c.NextArg() {
+					return c.ArgErr()
+				}
+				listen = c.Val()
+			case "path":
+				if !c.NextArg() {
+					return c.ArgErr()
+				}
+				path = c.Val()
+			}
+		}
+	}
+	if listen == "" {
+		listen = ":9180"
+	}
+	if path == "" {
+		path = "/metrics"
+	}
+
+	if metricsStarted {
+		return nil
+	}
+	metricsStarted = true
+
+	c.OnStartup(func() error {
+		mux := http.NewServeMux()
+		mux.Handle(path, promhttp.Handler())
+		go func() {
+			if err := http.ListenAndServe(listen, mux); err != nil {
+				logEvent(logEntry{Level: "error", Message: "cauth_metrics listener stopped", Error: err.Error()})
+			}
+		}()
+		return nil
+	})
+	return nil
+}
@@ -0,0 +1,64 @@
+package cauth
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+	"github.com/pkg/errors"
+)
+
+// remoteIP strips the port from r.RemoteAddr, falling back to the raw
+// value for addresses that don't have one (e.g. when set directly by a
+// test or a non-TCP listener), so policy expressions can compare it
+// against a bare IP.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// compilePolicy compiles a post-authorization policy expression once,
+// at Caddyfile parse time, so ServeHTTP only has to evaluate it against
+// a per-request context.
+func compilePolicy(src string) (*vm.Program, error) {
+	prog, err := expr.Compile(src)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't compile policy expression")
+	}
+	return prog, nil
+}
+
+// evalPolicy runs a rule's compiled policy against the outcome of
+// authorization (headers and claims) plus the inbound request, exposed
+// to the expression as `user`, `headers` and `request`.
+func evalPolicy(prog *vm.Program, r *http.Request, headers map[string]string, claims map[string]interface{}) (bool, error) {
+	query := map[string]string{}
+	for k := range r.URL.Query() {
+		query[k] = r.URL.Query().Get(k)
+	}
+
+	env := map[string]interface{}{
+		"user":    claims,
+		"headers": headers,
+		"request": map[string]interface{}{
+			"path":      r.URL.Path,
+			"method":    r.Method,
+			"remote_ip": remoteIP(r),
+			"query":     query,
+		},
+	}
+
+	out, err := expr.Run(prog, env)
+	if err != nil {
+		return false, errors.Wrap(err, "couldn't evaluate policy expression")
+	}
+	allowed, ok := out.(bool)
+	if !ok {
+		return false, errors.New("policy expression must evaluate to a bool")
+	}
+	return allowed, nil
+}
@@ -0,0 +1,74 @@
+package cauth
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEvalPolicyMatchesRemoteIPWithoutPort(t *testing.T) {
+	prog, err := compilePolicy(`request.remote_ip == "203.0.113.5"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "203.0.113.5:54211"
+
+	allowed, err := evalPolicy(prog, r, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !allowed {
+		t.Fatalf("expected remote_ip to match after stripping the port")
+	}
+}
+
+func TestEvalPolicyHandlesRemoteAddrWithoutPort(t *testing.T) {
+	prog, err := compilePolicy(`request.remote_ip == "203.0.113.5"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "203.0.113.5"
+
+	allowed, err := evalPolicy(prog, r, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !allowed {
+		t.Fatalf("expected a portless RemoteAddr to be used as-is")
+	}
+}
+
+func TestEvalPolicyExposesUserHeadersAndRequest(t *testing.T) {
+	prog, err := compilePolicy(`user.role == "admin" && headers["X-Team"] == "eng" && request.path == "/admin" && request.method == "GET" && request.query["debug"] == "1"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("GET", "/admin?debug=1", nil)
+
+	allowed, err := evalPolicy(prog, r, map[string]string{"X-Team": "eng"}, map[string]interface{}{"role": "admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !allowed {
+		t.Fatalf("expected the policy to see user/headers/request fields")
+	}
+}
+
+func TestEvalPolicyRejectsNonBoolResult(t *testing.T) {
+	prog, err := compilePolicy(`request.path`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("GET", "/admin", nil)
+
+	if _, err := evalPolicy(prog, r, nil, nil); err == nil {
+		t.Fatalf("expected a non-bool policy result to error")
+	}
+}
+
+func TestCompilePolicyRejectsInvalidExpression(t *testing.T) {
+	if _, err := compilePolicy(`this is not valid expr syntax {{{`); err == nil {
+		t.Fatalf("expected an invalid policy expression to fail to compile")
+	}
+}
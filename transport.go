@@ -0,0 +1,156 @@
+package cauth
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/pkg/errors"
+)
+
+// Transport abstracts how a rule's authorization decision is obtained.
+// The default is jsonTransport (POST a bespoke JSON body and expect a
+// JSON header/claims map back); forwardAuthTransport and grpcTransport
+// (see grpc.go) let cauth plug into existing ext-auth deployments.
+type Transport interface {
+	Call(r *http.Request, rule Rule, data *authReq) (headers map[string]string, claims map[string]interface{}, code int, err error)
+}
+
+// newTransport builds the Transport selected by rule.EndpointType,
+// defaulting to the original JSON behavior when unset.
+func newTransport(rule Rule) (Transport, error) {
+	switch rule.EndpointType {
+	case "", "json":
+		return jsonTransport{}, nil
+	case "forward_auth":
+		return forwardAuthTransport{}, nil
+	case "grpc":
+		return newGRPCTransport(rule)
+	default:
+		return nil, errors.Errorf("unknown endpoint_type %q", rule.EndpointType)
+	}
+}
+
+// jsonTransport is the original transport: POST the authReq payload as
+// JSON and decode an authResp (headers + claims) from a 200 response.
+type jsonTransport struct{}
+
+func (jsonTransport) Call(r *http.Request, rule Rule, data *authReq) (headers map[string]string, claims map[string]interface{}, code int, err error) {
+	req := &http.Request{}
+	req.URL, err = url.Parse(rule.Endpoint)
+	if err != nil {
+		err = errors.Wrap(err, "couldn't parse endpoint")
+		return
+	}
+	req.Method = "POST"
+
+	var body []byte
+	body, err = json.Marshal(data)
+	if err != nil {
+		err = errors.Wrap(err, "couldn't marshal headers")
+		return
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	cli := rule.client
+	if cli == nil {
+		cli = httpCli
+	}
+	var res *http.Response
+	res, err = cli.Do(req)
+	if err != nil {
+		err = errors.Wrap(err, "error contacting endpoint")
+		return
+	}
+	defer res.Body.Close()
+	code = res.StatusCode
+	if res.StatusCode != 200 {
+		err = errors.New("Not authorized")
+		return
+	}
+	headers, claims, err = decodeAuthResponse(res.Body)
+	return
+}
+
+// decodeAuthResponse decodes a 200 auth endpoint response, accepting
+// both the current {"headers": {...}, "claims": {...}} shape and the
+// original flat {"X-Header": "value"} shape returned by endpoints
+// written before chunk0-3. Without this fallback, an endpoint still on
+// the old wire format would decode cleanly into an authResp with nil
+// Headers/Claims and silently authorize every request with no headers
+// injected.
+func decodeAuthResponse(body io.Reader) (headers map[string]string, claims map[string]interface{}, err error) {
+	raw, err := ioutil.ReadAll(body)
+	if err != nil {
+		err = errors.Wrap(err, "couldn't read response")
+		return
+	}
+
+	var resp authResp
+	if err = json.Unmarshal(raw, &resp); err != nil {
+		err = errors.Wrap(err, "couldn't decode response")
+		return
+	}
+	if resp.Headers == nil && resp.Claims == nil {
+		var flat map[string]string
+		if jsonErr := json.Unmarshal(raw, &flat); jsonErr == nil {
+			resp.Headers = flat
+		}
+	}
+	headers = resp.Headers
+	claims = resp.Claims
+	return
+}
+
+// forwardAuthTransport replays the original request's method and path
+// to Endpoint with X-Forwarded-* headers, the way Traefik and nginx's
+// ext-auth integrations do, and copies rule.CopyHeaders back from the
+// auth response onto the downstream request.
+type forwardAuthTransport struct{}
+
+func (forwardAuthTransport) Call(r *http.Request, rule Rule, data *authReq) (headers map[string]string, claims map[string]interface{}, code int, err error) {
+	req, err := http.NewRequest(r.Method, rule.Endpoint, nil)
+	if err != nil {
+		err = errors.Wrap(err, "couldn't build forward-auth request")
+		return
+	}
+	req.Header = r.Header.Clone()
+	req.Header.Set("X-Forwarded-Method", r.Method)
+	req.Header.Set("X-Forwarded-Proto", forwardedProto(r))
+	req.Header.Set("X-Forwarded-Host", r.Host)
+	req.Header.Set("X-Forwarded-Uri", r.URL.RequestURI())
+
+	cli := rule.client
+	if cli == nil {
+		cli = httpCli
+	}
+	var res *http.Response
+	res, err = cli.Do(req)
+	if err != nil {
+		err = errors.Wrap(err, "error contacting endpoint")
+		return
+	}
+	defer res.Body.Close()
+	code = res.StatusCode
+	if code != http.StatusOK {
+		err = errors.New("Not authorized")
+		return
+	}
+
+	headers = map[string]string{}
+	for _, h := range rule.CopyHeaders {
+		if v := res.Header.Get(h); v != "" {
+			headers[h] = v
+		}
+	}
+	return
+}
+
+func forwardedProto(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
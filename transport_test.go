@@ -0,0 +1,141 @@
+package cauth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewTransportDefaultsToJSON(t *testing.T) {
+	tr, err := newTransport(Rule{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := tr.(jsonTransport); !ok {
+		t.Fatalf("expected an empty endpoint_type to default to jsonTransport, got %T", tr)
+	}
+}
+
+func TestNewTransportRejectsUnknownType(t *testing.T) {
+	if _, err := newTransport(Rule{EndpointType: "carrier-pigeon"}); err == nil {
+		t.Fatalf("expected an unknown endpoint_type to error")
+	}
+}
+
+func TestDecodeAuthResponseCurrentShape(t *testing.T) {
+	body := strings.NewReader(`{"headers":{"X-User":"alice"},"claims":{"role":"admin"}}`)
+	headers, claims, err := decodeAuthResponse(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if headers["X-User"] != "alice" {
+		t.Fatalf("expected headers to decode, got %v", headers)
+	}
+	if claims["role"] != "admin" {
+		t.Fatalf("expected claims to decode, got %v", claims)
+	}
+}
+
+func TestDecodeAuthResponseLegacyFlatShape(t *testing.T) {
+	// Endpoints written before chunk0-3 return a flat {"X-Header": "value"}
+	// body with no "headers"/"claims" wrapper.
+	body := strings.NewReader(`{"X-User":"alice","X-Team":"eng"}`)
+	headers, claims, err := decodeAuthResponse(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if headers["X-User"] != "alice" || headers["X-Team"] != "eng" {
+		t.Fatalf("expected the flat shape to be treated as headers, got %v", headers)
+	}
+	if claims != nil {
+		t.Fatalf("expected no claims from the legacy shape, got %v", claims)
+	}
+}
+
+func TestJSONTransportCallPostsAndDecodes(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			t.Errorf("expected a POST, got %s", r.Method)
+		}
+		w.Write([]byte(`{"headers":{"X-User":"bob"}}`))
+	}))
+	defer srv.Close()
+
+	rule := Rule{Endpoint: srv.URL, client: srv.Client()}
+	r := httptest.NewRequest("GET", "/", nil)
+
+	headers, _, code, err := jsonTransport{}.Call(r, rule, &authReq{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if headers["X-User"] != "bob" {
+		t.Fatalf("expected X-User=bob, got %v", headers)
+	}
+}
+
+func TestJSONTransportCallReturnsErrorOnNon200(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	rule := Rule{Endpoint: srv.URL, client: srv.Client()}
+	r := httptest.NewRequest("GET", "/", nil)
+
+	_, _, code, err := jsonTransport{}.Call(r, rule, &authReq{})
+	if err == nil {
+		t.Fatalf("expected a non-200 response to error")
+	}
+	if code != http.StatusForbidden {
+		t.Fatalf("expected code to be set to 403, got %d", code)
+	}
+}
+
+func TestForwardAuthTransportCopiesConfiguredHeaders(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("X-Forwarded-Method"); got != "GET" {
+			t.Errorf("expected X-Forwarded-Method=GET, got %q", got)
+		}
+		if got := r.Header.Get("X-Forwarded-Uri"); got != "/secret" {
+			t.Errorf("expected X-Forwarded-Uri=/secret, got %q", got)
+		}
+		w.Header().Set("X-User", "carol")
+		w.Header().Set("X-Not-Copied", "nope")
+	}))
+	defer srv.Close()
+
+	rule := Rule{Endpoint: srv.URL, client: srv.Client(), CopyHeaders: []string{"X-User"}}
+	r := httptest.NewRequest("GET", "/secret", nil)
+
+	headers, _, code, err := forwardAuthTransport{}.Call(r, rule, &authReq{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if headers["X-User"] != "carol" {
+		t.Fatalf("expected X-User=carol copied back, got %v", headers)
+	}
+	if _, ok := headers["X-Not-Copied"]; ok {
+		t.Fatalf("expected only CopyHeaders entries to be copied, got %v", headers)
+	}
+}
+
+func TestForwardAuthTransportRejectsNon200(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	rule := Rule{Endpoint: srv.URL, client: srv.Client()}
+	r := httptest.NewRequest("GET", "/", nil)
+
+	if _, _, code, err := (forwardAuthTransport{}).Call(r, rule, &authReq{}); err == nil || code != http.StatusUnauthorized {
+		t.Fatalf("expected a 401 to be rejected, got code=%d err=%v", code, err)
+	}
+}